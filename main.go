@@ -1,21 +1,23 @@
 package main
 
 import (
-	"archive/tar"
-	"compress/gzip"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
-	"github.com/ulikunitz/xz"
-
 	"github.com/taylormonacelli/tidehead"
+
+	"github.com/taylormonacelli/coldsprite/pkg/archive"
 )
 
 // Define a struct that matches the JSON data structure
@@ -29,6 +31,19 @@ type Manifest struct {
 	RepoPath    string `json:"RepoPath"`
 }
 
+// Result is the outcome of extracting a single manifest, produced by the
+// worker pool in main and aggregated into the end-of-run summary.
+type Result struct {
+	Manifest    Manifest
+	OutputDir   string
+	Compression archive.Compression
+	// Bytes is the size of the (possibly compressed) archive read from
+	// disk, not the size of the data extracted from it.
+	Bytes    int64
+	Duration time.Duration
+	Err      error
+}
+
 func findMatchingFiles(directory string) ([]string, error) {
 	var matchingFiles []string
 
@@ -55,189 +70,241 @@ func findMatchingFiles(directory string) ([]string, error) {
 	return matchingFiles, nil
 }
 
-func expandXZFile(logger *slog.Logger, inputFile string, outputDir string) error {
-	logger.Debug("Expanding XZ file %s to %s", inputFile, outputDir)
+// ctxReader wraps r so that Read returns ctx.Err() once ctx is done,
+// letting a SIGINT interrupt an in-flight io.Copy inside the extractor
+// instead of waiting for it to finish.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
 
-	// Create the output directory if it doesn't exist
-	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
-		return err
+func (c ctxReader) Read(p []byte) (int, error) {
+	select {
+	case <-c.ctx.Done():
+		return 0, c.ctx.Err()
+	default:
+		return c.r.Read(p)
 	}
+}
 
-	// Open the XZ-compressed file for reading
+// extractManifest detects the manifest's archive's compression, extracts
+// it into outputDir via pkg/archive, and reports how many (compressed)
+// bytes were read from inputFile.
+func extractManifest(ctx context.Context, logger *slog.Logger, inputFile, outputDir string) (archive.Compression, int64, error) {
 	file, err := os.Open(inputFile)
 	if err != nil {
-		return err
+		return archive.Uncompressed, 0, fmt.Errorf("opening %s: %w", inputFile, err)
 	}
 	defer file.Close()
 
-	// Create an XZ reader
-	r, err := xz.NewReader(file)
-	if err != nil {
-		return err
+	header := make([]byte, 10)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return archive.Uncompressed, 0, fmt.Errorf("reading header of %s: %w", inputFile, err)
 	}
-
-	// Create the output file
-	outputFile := filepath.Join(outputDir, filepath.Base(inputFile))
-
-	// Determine the output filename without extension
-	outputFile = strings.TrimSuffix(filepath.Base(outputFile), filepath.Ext(outputFile))
-
-	// Create the output filename with the ".tar" extension
-	outputFile = filepath.Join(outputDir, outputFile)
-
-	output, err := os.Create(outputFile)
-	if err != nil {
-		return err
+	nameHint := filepath.Base(inputFile)
+	compression := archive.DetectCompression(header[:n])
+	if compression == archive.Uncompressed {
+		// Magic bytes were inconclusive (e.g. too short); fall back to
+		// the extension, same as the Untar call below will.
+		compression = archive.DetectCompressionFromName(nameHint)
 	}
-	defer output.Close()
 
-	// Copy the decompressed data to the output file
-	_, err = io.Copy(output, r)
-	if err != nil {
-		return err
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return compression, 0, fmt.Errorf("rewinding %s: %w", inputFile, err)
 	}
 
-	// Check if the expanded file has a .tar extension
-	if strings.HasSuffix(outputFile, ".tar") {
-		// The expanded file is a TAR file, so we should expand it
-		tarOutputDir := outputDir // Use the same output directory
-		if err := expandTarFile(logger, outputFile, tarOutputDir); err != nil {
-			logger.Debug(fmt.Sprintf("Error expanding TAR file %s: %v", outputFile, err))
-		} else {
-			logger.Debug(fmt.Sprintf("TAR File expanded successfully: %s -> %s", outputFile, tarOutputDir))
-		}
+	counting := &countingReader{r: ctxReader{ctx: ctx, r: file}}
+	opts := &archive.TarOptions{NameHint: nameHint}
+	if err := archive.Untar(counting, outputDir, opts); err != nil {
+		return compression, counting.n, fmt.Errorf("extracting %s: %w", inputFile, err)
 	}
 
-	return nil
+	logger.Debug(fmt.Sprintf("extracted %s -> %s", inputFile, outputDir))
+	return compression, counting.n, nil
 }
 
-func expandTarGzFile(logger *slog.Logger, inputFile string, outputDir string) error {
-	logger.Debug(fmt.Sprintf("Expanding TAR GZ file %s to %s", inputFile, outputDir))
+// countingReader tallies bytes read so extractManifest can report how
+// much compressed data a manifest's archive contained.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
 
-	// Create the output directory if it doesn't exist
-	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
-		return err
-	}
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
 
-	// Open the TAR GZ file for reading
-	file, err := os.Open(inputFile)
-	if err != nil {
-		return err
+func epochToDuration(i int64) string {
+	e := time.Unix(i, 0)
+	now := time.Now()
+	duration := now.Sub(e)
+
+	friendly := tidehead.FormatDuration(duration)
+	return friendly
+}
+
+// processManifests fans manifests out to a bounded pool of workers and
+// returns one Result per manifest handed to a worker, i.e. each manifest
+// pendingManifests keeps. If failFast is true, the first error cancels
+// ctx so queued and in-flight extractions stop promptly instead of
+// running to completion; manifests not yet handed to a worker by then
+// get no Result.
+func processManifests(ctx context.Context, logger *slog.Logger, manifests []Manifest, workers int, failFast bool) []Result {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pending := pendingManifests(logger, manifests)
+
+	jobs := make(chan Manifest)
+	results := make(chan Result)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for manifest := range jobs {
+				results <- runOne(ctx, logger, manifest)
+			}
+		}()
 	}
-	defer file.Close()
 
-	// Create a GZIP reader
-	gzipReader, err := gzip.NewReader(file)
-	if err != nil {
-		return err
+	go func() {
+		defer close(jobs)
+		for _, manifest := range pending {
+			select {
+			case <-ctx.Done():
+				return
+			case jobs <- manifest:
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var collected []Result
+	for result := range results {
+		collected = append(collected, result)
+		if failFast && result.Err != nil {
+			cancel()
+		}
 	}
-	defer gzipReader.Close()
 
-	// Create a TAR reader
-	tarReader := tar.NewReader(gzipReader)
+	return collected
+}
+
+// pendingManifests filters out manifests whose output directory already
+// exists and deduplicates by output directory, so the worker pool never
+// has two goroutines racing to extract into the same destination (e.g.
+// two manifests sharing a TimeEpoch from a retried capture).
+func pendingManifests(logger *slog.Logger, manifests []Manifest) []Manifest {
+	seen := make(map[string]bool, len(manifests))
+	pending := make([]Manifest, 0, len(manifests))
 
-	// Extract the contents of the TAR file
-	for {
-		header, err := tarReader.Next()
+	for _, manifest := range manifests {
+		outputDir := manifestOutputDir(manifest)
 
-		if err == io.EOF {
-			break
+		if _, err := os.Stat(outputDir); err == nil {
+			logger.Debug(fmt.Sprintf("%s: already extracted, skipping", outputDir))
+			continue
 		}
-		if err != nil {
-			return err
+		if seen[outputDir] {
+			logger.Debug(fmt.Sprintf("%s: duplicate output directory, skipping", outputDir))
+			continue
 		}
+		seen[outputDir] = true
 
-		// Construct the output path
-		outputPath := filepath.Join(outputDir, header.Name)
-
-		// Check if the file is a directory or a regular file
-		if header.FileInfo().IsDir() {
-			// Create directories as needed
-			os.MkdirAll(outputPath, os.ModePerm)
-		} else {
-			// Create the output file
-			outputFile, err := os.Create(outputPath)
-			if err != nil {
-				return err
-			}
-			defer outputFile.Close()
-
-			// Copy the file content from the TAR archive to the output file
-			_, err = io.Copy(outputFile, tarReader)
-			if err != nil {
-				return err
-			}
-		}
+		pending = append(pending, manifest)
 	}
 
-	return nil
+	return pending
 }
 
-func expandTarFile(logger *slog.Logger, inputFile string, outputDir string) error {
-	logger.Debug(fmt.Sprintf("Expanding TAR file %s to %s", inputFile, outputDir))
+func manifestOutputDir(manifest Manifest) string {
+	outputDir := filepath.Join("data/logs/expanded", strconv.FormatInt(manifest.TimeEpoch, 10))
+	outputDir, _ = filepath.Abs(outputDir)
+	return outputDir
+}
 
-	// Create the output directory if it doesn't exist
-	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
-		return err
-	}
+func runOne(ctx context.Context, logger *slog.Logger, manifest Manifest) Result {
+	outputDir := manifestOutputDir(manifest)
 
-	// Open the TAR file for reading
-	file, err := os.Open(inputFile)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+	d := epochToDuration(manifest.TimeEpoch)
+	logger.Debug(fmt.Sprintf("%s age %s ago", manifest.FileName, d))
 
-	// Create a TAR reader
-	tarReader := tar.NewReader(file)
+	inputFile := filepath.Join("data/logs", manifest.FileName)
 
-	// Extract the contents of the TAR file
-	for {
-		header, err := tarReader.Next()
+	start := time.Now()
+	compression, bytesRead, err := extractManifest(ctx, logger, inputFile, outputDir)
+	return Result{
+		Manifest:    manifest,
+		OutputDir:   outputDir,
+		Compression: compression,
+		Bytes:       bytesRead,
+		Duration:    time.Since(start),
+		Err:         err,
+	}
+}
 
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
+func compressionName(c archive.Compression) string {
+	switch c {
+	case archive.Gzip:
+		return "gzip"
+	case archive.Bzip2:
+		return "bzip2"
+	case archive.Xz:
+		return "xz"
+	case archive.Zstd:
+		return "zstd"
+	default:
+		return "uncompressed"
+	}
+}
 
-		// Construct the output path
-		outputPath := filepath.Join(outputDir, header.Name)
-
-		// Check if the file is a directory or a regular file
-		if header.FileInfo().IsDir() {
-			// Create directories as needed
-			os.MkdirAll(outputPath, os.ModePerm)
-		} else {
-			// Create the output file
-			outputFile, err := os.Create(outputPath)
-			if err != nil {
-				return err
-			}
-			defer outputFile.Close()
+// printSummary reports counts by codec, total archive bytes read, and
+// any failed manifests with their reasons.
+func printSummary(results []Result) {
+	byCodec := map[string]int{}
+	var totalBytes int64
+	var failures []Result
 
-			// Copy the file content from the TAR archive to the output file
-			_, err = io.Copy(outputFile, tarReader)
-			if err != nil {
-				return err
-			}
+	for _, r := range results {
+		if r.Err != nil {
+			failures = append(failures, r)
+			continue
 		}
+		byCodec[compressionName(r.Compression)]++
+		totalBytes += r.Bytes
 	}
 
-	return nil
+	fmt.Printf("extracted %d manifest(s), %d archive byte(s) read\n", len(results)-len(failures), totalBytes)
+	for codec, count := range byCodec {
+		fmt.Printf("  %s: %d\n", codec, count)
+	}
+	if len(failures) > 0 {
+		fmt.Printf("failed: %d\n", len(failures))
+		for _, f := range failures {
+			fmt.Printf("  %s: %v\n", f.Manifest.FileName, f.Err)
+		}
+	}
 }
 
-func epochToDuration(i int64) string {
-	e := time.Unix(i, 0)
-	now := time.Now()
-	duration := now.Sub(e)
+func main() {
+	workers := flag.Int("workers", runtime.NumCPU(), "number of manifests to extract concurrently")
+	failFast := flag.Bool("fail-fast", false, "stop extracting remaining manifests after the first error")
+	flag.Parse()
 
-	friendly := tidehead.FormatDuration(duration)
-	return friendly
-}
+	if *workers < 1 {
+		fmt.Fprintf(os.Stderr, "-workers must be at least 1, got %d\n", *workers)
+		os.Exit(1)
+	}
 
-func main() {
 	handlerIngoreDebug := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})
 	loggerIgnoreDebug := slog.New(handlerIngoreDebug)
 	slog.SetDefault(loggerIgnoreDebug)
@@ -263,6 +330,9 @@ func main() {
 
 	logger := slog.New(handler)
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
 	// Specify the directory to search
 	searchDirectory := "data/logs"
 
@@ -284,18 +354,18 @@ func main() {
 			logger.Debug(fmt.Sprintf("Error opening file %s: %v", filePath, err))
 			continue
 		}
-		defer file.Close()
-
-		// Initialize a variable to hold the unmarshaled data
-		var manifest Manifest
 
 		// Read the file contents
 		data, err := io.ReadAll(file)
+		file.Close()
 		if err != nil {
 			logger.Debug(fmt.Sprintf("Error reading file %s: %v", filePath, err))
 			continue
 		}
 
+		// Initialize a variable to hold the unmarshaled data
+		var manifest Manifest
+
 		// Unmarshal the JSON data into the struct
 		if err := json.Unmarshal(data, &manifest); err != nil {
 			logger.Debug(fmt.Sprintf("Error unmarshaling JSON from file %s: %v", filePath, err))
@@ -306,47 +376,6 @@ func main() {
 		manifests = append(manifests, manifest)
 	}
 
-	// Process the list of unmarshaled data as needed
-	for _, manifest := range manifests {
-		// Check if the directory exists
-		outputDir := filepath.Join("data/logs/expanded", strconv.FormatInt(manifest.TimeEpoch, 10))
-		outputDir, _ = filepath.Abs(outputDir)
-		tarPath := filepath.Join(outputDir, manifest.FileName)
-
-		d := epochToDuration(manifest.TimeEpoch)
-		logger.Debug(fmt.Sprintf("%s: checking existance of directory: %s", d, outputDir))
-		if _, err := os.Stat(outputDir); os.IsNotExist(err) {
-			// Directory does not exist, expand the file based on file extension
-
-			d := epochToDuration(manifest.TimeEpoch)
-			logger.Debug(fmt.Sprintf("%s age %s ago", tarPath, d))
-
-			inputFile := fmt.Sprintf("data/logs/%s", manifest.FileName)
-
-			// Determine the file extension and expand accordingly
-			fileExtension := strings.ToLower(filepath.Ext(inputFile))
-			switch fileExtension {
-			case ".xz":
-				if err := expandXZFile(logger, inputFile, outputDir); err != nil {
-					logger.Debug(fmt.Sprintf("Error expanding XZ file %s: %v", inputFile, err))
-				} else {
-					logger.Debug(fmt.Sprintf("XZ File expanded successfully: %s -> %s", inputFile, outputDir))
-				}
-			case ".gz":
-				if err := expandTarGzFile(logger, inputFile, outputDir); err != nil {
-					logger.Debug(fmt.Sprintf("Error expanding TAR GZ file %s: %v", inputFile, err))
-				} else {
-					logger.Debug(fmt.Sprintf("TAR GZ File expanded successfully: %s -> %s", inputFile, outputDir))
-				}
-			case ".tar":
-				if err := expandTarFile(logger, inputFile, outputDir); err != nil {
-					logger.Debug(fmt.Sprintf("Error expanding TAR file %s: %v", inputFile, err))
-				} else {
-					logger.Debug(fmt.Sprintf("TAR File expanded successfully: %s -> %s", inputFile, outputDir))
-				}
-			default:
-				logger.Debug(fmt.Sprintf("Unsupported file format for %s", inputFile))
-			}
-		}
-	}
+	results := processManifests(ctx, logger, manifests, *workers, *failFast)
+	printSummary(results)
 }
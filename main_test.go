@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestPendingManifestsSkipsAlreadyExtractedAndDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Chdir: %v", err)
+	}
+	defer os.Chdir(cwd)
+
+	extracted := filepath.Join(dir, "data/logs/expanded", "100")
+	if err := os.MkdirAll(extracted, os.ModePerm); err != nil {
+		t.Fatalf("seeding already-extracted output dir: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		manifests []Manifest
+		want      []int64 // TimeEpoch of manifests expected to remain pending
+	}{
+		{
+			name:      "already extracted is skipped",
+			manifests: []Manifest{{TimeEpoch: 100, FileName: "manifest_100.json"}},
+			want:      nil,
+		},
+		{
+			name: "duplicate TimeEpoch keeps only the first",
+			manifests: []Manifest{
+				{TimeEpoch: 200, FileName: "manifest_200.json"},
+				{TimeEpoch: 200, FileName: "manifest_200_retry.json"},
+			},
+			want: []int64{200},
+		},
+		{
+			name: "distinct pending manifests all pass through",
+			manifests: []Manifest{
+				{TimeEpoch: 300, FileName: "manifest_300.json"},
+				{TimeEpoch: 400, FileName: "manifest_400.json"},
+			},
+			want: []int64{300, 400},
+		},
+	}
+
+	logger := discardLogger()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pending := pendingManifests(logger, tt.manifests)
+
+			var got []int64
+			for _, m := range pending {
+				got = append(got, m.TimeEpoch)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("pendingManifests() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("pendingManifests()[%d] = %d, want %d", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// BenchmarkDecompressStream measures DecompressStream throughput against
+// every regular file directly under data/logs (the manifest JSON files
+// included), one benchmark case per file. Run with `go test -bench=DecompressStream -benchmem ./pkg/archive`
+// from the repo root to compare codecs on real samples; it's skipped if
+// data/logs doesn't exist or is empty, e.g. in CI checkouts without the
+// sample corpus.
+func BenchmarkDecompressStream(b *testing.B) {
+	samples, err := filepath.Glob("../../data/logs/*")
+	if err != nil || len(samples) == 0 {
+		b.Skip("no samples found under data/logs")
+	}
+
+	for _, path := range samples {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		b.Run(filepath.Base(path), func(b *testing.B) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				b.Fatalf("reading %s: %v", path, err)
+			}
+
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				r, err := DecompressStream(bytes.NewReader(data))
+				if err != nil {
+					b.Fatalf("DecompressStream: %v", err)
+				}
+				if _, err := io.Copy(io.Discard, r); err != nil {
+					b.Fatalf("reading decompressed stream: %v", err)
+				}
+				r.Close()
+			}
+		})
+	}
+}
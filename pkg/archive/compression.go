@@ -0,0 +1,141 @@
+// Package archive provides tar extraction helpers with compression
+// auto-detection, shared by coldsprite and other tools in the org.
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression identifies the codec a stream was compressed with.
+type Compression int
+
+const (
+	Uncompressed Compression = iota
+	Gzip
+	Bzip2
+	Xz
+	Zstd
+)
+
+// magic bytes used to identify a compressed stream regardless of its
+// file extension. Ordered longest-prefix-first isn't required since
+// each signature is unambiguous on its own.
+var magicNumbers = []struct {
+	compression Compression
+	sig         []byte
+}{
+	{Zstd, []byte{0x28, 0xB5, 0x2F, 0xFD}},
+	{Xz, []byte{0xFD, 0x37, 0x7A, 0x58, 0x5A, 0x00}},
+	{Gzip, []byte{0x1F, 0x8B, 0x08}},
+	{Bzip2, []byte{0x42, 0x5A, 0x68}},
+}
+
+// DetectCompression inspects the first few bytes of a stream and returns
+// the Compression it matches, or Uncompressed if none of the known
+// signatures are found. header should contain at least the first 10
+// bytes of the stream; shorter input simply fails to match.
+func DetectCompression(header []byte) Compression {
+	for _, m := range magicNumbers {
+		if bytes.HasPrefix(header, m.sig) {
+			return m.compression
+		}
+	}
+	return Uncompressed
+}
+
+// DetectCompressionFromName guesses a stream's Compression from its
+// filename extension, including the compound extensions (.tgz, .tbz2,
+// .tzst) that imply a tar archive underneath. It is a fallback for
+// inputs DetectCompression can't read a conclusive signature from (a
+// stream shorter than the signature, or simply not peeked); callers
+// should prefer DetectCompression and only consult this when that
+// returns Uncompressed.
+func DetectCompressionFromName(name string) Compression {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz", ".tgz":
+		return Gzip
+	case ".bz2", ".tbz2":
+		return Bzip2
+	case ".xz", ".txz":
+		return Xz
+	case ".zst", ".zstd", ".tzst":
+		return Zstd
+	default:
+		return Uncompressed
+	}
+}
+
+// decompress wraps buf in the decoder for c, or returns buf unchanged
+// for Uncompressed.
+func decompress(buf *bufio.Reader, c Compression) (io.ReadCloser, error) {
+	switch c {
+	case Gzip:
+		gzReader, err := gzip.NewReader(buf)
+		if err != nil {
+			return nil, fmt.Errorf("archive: creating gzip reader: %w", err)
+		}
+		return gzReader, nil
+	case Bzip2:
+		return io.NopCloser(bzip2.NewReader(buf)), nil
+	case Xz:
+		xzReader, err := xz.NewReader(buf)
+		if err != nil {
+			return nil, fmt.Errorf("archive: creating xz reader: %w", err)
+		}
+		return io.NopCloser(xzReader), nil
+	case Zstd:
+		zstdReader, err := zstd.NewReader(buf)
+		if err != nil {
+			return nil, fmt.Errorf("archive: creating zstd reader: %w", err)
+		}
+		return zstdReader.IOReadCloser(), nil
+	default:
+		return io.NopCloser(buf), nil
+	}
+}
+
+// DecompressStream peeks the first bytes of r, determines its
+// Compression via DetectCompression, and returns a ReadCloser that
+// yields the decompressed bytes. For Uncompressed input the returned
+// ReadCloser simply wraps r. Callers are responsible for closing the
+// result.
+func DecompressStream(r io.Reader) (io.ReadCloser, error) {
+	buf := bufio.NewReader(r)
+
+	header, err := buf.Peek(10)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("archive: peeking stream header: %w", err)
+	}
+
+	return decompress(buf, DetectCompression(header))
+}
+
+// DecompressStreamHint behaves like DecompressStream, but when
+// DetectCompression can't read a conclusive signature from r's header
+// it falls back to DetectCompressionFromName(name). The extension is
+// only ever consulted once magic bytes have had their say.
+func DecompressStreamHint(r io.Reader, name string) (io.ReadCloser, error) {
+	buf := bufio.NewReader(r)
+
+	header, err := buf.Peek(10)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("archive: peeking stream header: %w", err)
+	}
+
+	c := DetectCompression(header)
+	if c == Uncompressed && name != "" {
+		c = DetectCompressionFromName(name)
+	}
+
+	return decompress(buf, c)
+}
@@ -0,0 +1,144 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+)
+
+func TestDetectCompression(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   Compression
+	}{
+		{"gzip", []byte{0x1F, 0x8B, 0x08, 0x00}, Gzip},
+		{"bzip2", []byte{0x42, 0x5A, 0x68, 0x39}, Bzip2},
+		{"xz", []byte{0xFD, 0x37, 0x7A, 0x58, 0x5A, 0x00, 0x00}, Xz},
+		{"zstd", []byte{0x28, 0xB5, 0x2F, 0xFD}, Zstd},
+		{"tar", []byte("manifest_123.log"), Uncompressed},
+		{"short", []byte{0x1F}, Uncompressed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectCompression(tt.header); got != tt.want {
+				t.Errorf("DetectCompression(%v) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectCompressionFromName(t *testing.T) {
+	tests := []struct {
+		name string
+		want Compression
+	}{
+		{"archive.gz", Gzip},
+		{"archive.tgz", Gzip},
+		{"archive.bz2", Bzip2},
+		{"archive.tbz2", Bzip2},
+		{"archive.xz", Xz},
+		{"archive.txz", Xz},
+		{"archive.zst", Zstd},
+		{"archive.zstd", Zstd},
+		{"archive.tzst", Zstd},
+		{"manifest_123.log", Uncompressed},
+		{"archive.tar", Uncompressed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectCompressionFromName(tt.name); got != tt.want {
+				t.Errorf("DetectCompressionFromName(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecompressStreamHintFallsBackWhenMagicIsInconclusive(t *testing.T) {
+	// "BZ" is the start of bzip2's "BZh" signature, but too short for
+	// DetectCompression to match on its own -- the only realistic way
+	// magic-byte detection misses a codec it would otherwise recognize.
+	r, err := DecompressStreamHint(bytes.NewBufferString("BZ"), "archive.bz2")
+	if err != nil {
+		t.Fatalf("DecompressStreamHint: %v", err)
+	}
+	defer r.Close()
+
+	// bzip2.NewReader doesn't validate eagerly, so success here would
+	// mean DecompressStreamHint treated "BZ" as plain uncompressed text
+	// instead of taking the extension-implied bzip2 path.
+	if _, err := io.ReadAll(r); err == nil {
+		t.Fatal("reading a truncated bzip2 stream succeeded, want a decode error proving the bzip2 fallback was taken")
+	}
+}
+
+func TestDecompressStreamHintPrefersMagicBytesOverName(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write([]byte("hello")); err != nil {
+		t.Fatalf("writing gzip payload: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("closing gzip writer: %v", err)
+	}
+
+	// name claims xz, but the stream is actually gzip; the magic-byte
+	// match must win.
+	r, err := DecompressStreamHint(&buf, "archive.xz")
+	if err != nil {
+		t.Fatalf("DecompressStreamHint: %v", err)
+	}
+	defer r.Close()
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading decompressed stream: %v", err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("got %q, want %q", got, "hello")
+	}
+}
+
+func TestDecompressStreamGzip(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write([]byte("hello")); err != nil {
+		t.Fatalf("writing gzip payload: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("closing gzip writer: %v", err)
+	}
+
+	r, err := DecompressStream(&buf)
+	if err != nil {
+		t.Fatalf("DecompressStream: %v", err)
+	}
+	defer r.Close()
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading decompressed stream: %v", err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("got %q, want %q", got, "hello")
+	}
+}
+
+func TestDecompressStreamUncompressed(t *testing.T) {
+	r, err := DecompressStream(bytes.NewBufferString("plain text"))
+	if err != nil {
+		t.Fatalf("DecompressStream: %v", err)
+	}
+	defer r.Close()
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading decompressed stream: %v", err)
+	}
+	if string(got) != "plain text" {
+		t.Errorf("got %q, want %q", got, "plain text")
+	}
+}
@@ -0,0 +1,48 @@
+package archive
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// buffer32K is the chunk size used by the pooled readers/writers below,
+// matching the Docker archive package's pools.BufioReader32KPool.
+const buffer32K = 32 * 1024
+
+var bufReaderPool = sync.Pool{
+	New: func() interface{} {
+		return bufio.NewReaderSize(nil, buffer32K)
+	},
+}
+
+var bufWriterPool = sync.Pool{
+	New: func() interface{} {
+		return bufio.NewWriterSize(nil, buffer32K)
+	},
+}
+
+// getBufReader returns a pooled *bufio.Reader wrapping r, avoiding a
+// fresh allocation per extracted file.
+func getBufReader(r io.Reader) *bufio.Reader {
+	buf := bufReaderPool.Get().(*bufio.Reader)
+	buf.Reset(r)
+	return buf
+}
+
+func putBufReader(buf *bufio.Reader) {
+	buf.Reset(nil)
+	bufReaderPool.Put(buf)
+}
+
+// getBufWriter returns a pooled *bufio.Writer wrapping w.
+func getBufWriter(w io.Writer) *bufio.Writer {
+	buf := bufWriterPool.Get().(*bufio.Writer)
+	buf.Reset(w)
+	return buf
+}
+
+func putBufWriter(buf *bufio.Writer) {
+	buf.Reset(nil)
+	bufWriterPool.Put(buf)
+}
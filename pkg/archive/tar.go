@@ -0,0 +1,341 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IDPair represents the owner to apply to extracted files when
+// NoLchown is false, mirroring the Docker archive package's IDPair.
+type IDPair struct {
+	UID int
+	GID int
+}
+
+// TarOptions controls how Untar/UntarPath extract an archive.
+type TarOptions struct {
+	// IncludePatterns, if non-empty, restricts extraction to headers
+	// whose name matches at least one glob pattern.
+	IncludePatterns []string
+	// ExcludePatterns skips headers matching any glob pattern, even if
+	// they also match IncludePatterns.
+	ExcludePatterns []string
+	// Compression forces a codec instead of relying on DecompressStream
+	// to auto-detect one. Leave at Uncompressed (the zero value) to
+	// auto-detect.
+	Compression Compression
+	// NoLchown disables applying ChownOpts to extracted entries.
+	NoLchown bool
+	// ChownOpts, when set and NoLchown is false, is applied as the owner
+	// of every extracted entry. The uid/gid recorded in the tar headers
+	// are not applied.
+	ChownOpts *IDPair
+	// NoOverwriteDirNonDir prevents a non-directory header from
+	// replacing an existing directory on disk, and vice versa.
+	NoOverwriteDirNonDir bool
+	// RebaseNames renames top-level entries during extraction: a header
+	// whose first path component matches a key is rewritten with the
+	// corresponding value before being joined to dest.
+	RebaseNames map[string]string
+	// NameHint is the filename of the original (possibly compressed)
+	// source stream, if known. It is never trusted as the source of
+	// truth: it's only consulted as a DetectCompression fallback when
+	// magic bytes are inconclusive, and to name the extracted file when
+	// the decompressed stream turns out not to be a tar archive at all.
+	NameHint string
+}
+
+// ErrBreakout is returned when a tar entry (or its symlink/hardlink
+// target) would extract outside the destination directory.
+type ErrBreakout struct {
+	Name string
+	Dest string
+}
+
+func (e *ErrBreakout) Error() string {
+	return fmt.Sprintf("archive: %q would extract outside of %q", e.Name, e.Dest)
+}
+
+// sanitizeExtractPath resolves header.Name against dest and verifies
+// the result stays within dest, guarding against zip-slip style path
+// traversal via "../" segments or absolute paths.
+func sanitizeExtractPath(name, dest string) (string, error) {
+	if filepath.IsAbs(name) || strings.HasPrefix(name, string(filepath.Separator)) {
+		return "", &ErrBreakout{Name: name, Dest: dest}
+	}
+	for _, part := range strings.Split(filepath.ToSlash(name), "/") {
+		if part == ".." {
+			return "", &ErrBreakout{Name: name, Dest: dest}
+		}
+	}
+
+	cleanDest := filepath.Clean(dest) + string(filepath.Separator)
+	target := filepath.Join(dest, name)
+	if target != filepath.Clean(dest) && !strings.HasPrefix(target+string(filepath.Separator), cleanDest) {
+		return "", &ErrBreakout{Name: name, Dest: dest}
+	}
+
+	return target, nil
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func rebaseName(name string, rebase map[string]string) string {
+	if len(rebase) == 0 {
+		return name
+	}
+	parts := strings.SplitN(filepath.ToSlash(name), "/", 2)
+	if to, ok := rebase[parts[0]]; ok {
+		parts[0] = to
+		return strings.Join(parts, "/")
+	}
+	return name
+}
+
+// UntarPath opens src, auto-detecting its compression, and extracts it
+// to dest. Unless opts already sets NameHint, it defaults to src's base
+// name.
+func UntarPath(src, dest string, opts *TarOptions) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("archive: opening %s: %w", src, err)
+	}
+	defer file.Close()
+
+	effectiveOpts := TarOptions{}
+	if opts != nil {
+		effectiveOpts = *opts
+	}
+	if effectiveOpts.NameHint == "" {
+		effectiveOpts.NameHint = filepath.Base(src)
+	}
+
+	return Untar(file, dest, &effectiveOpts)
+}
+
+// Untar reads the (possibly compressed) stream r and extracts it into
+// dest, which is created if it does not already exist. r need not
+// actually be a tar archive: if, once decompressed, it doesn't parse as
+// one, its bytes are written out as a single file under dest instead
+// (the file extension on opts.NameHint is only ever a hint, never the
+// source of truth for either compression or tar-ness).
+func Untar(r io.Reader, dest string, opts *TarOptions) error {
+	if opts == nil {
+		opts = &TarOptions{}
+	}
+
+	if err := os.MkdirAll(dest, os.ModePerm); err != nil {
+		return fmt.Errorf("archive: creating %s: %w", dest, err)
+	}
+
+	var decompressed io.ReadCloser
+	var err error
+	switch {
+	case opts.Compression != Uncompressed:
+		decompressed, err = decompress(bufio.NewReader(r), opts.Compression)
+	case opts.NameHint != "":
+		decompressed, err = DecompressStreamHint(r, opts.NameHint)
+	default:
+		decompressed, err = DecompressStream(r)
+	}
+	if err != nil {
+		return err
+	}
+	defer decompressed.Close()
+
+	bufReader := getBufReader(decompressed)
+	defer putBufReader(bufReader)
+
+	if !looksLikeTar(bufReader) {
+		return writeNonTarPayload(bufReader, dest, opts.NameHint)
+	}
+
+	tarReader := tar.NewReader(bufReader)
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("archive: reading tar header: %w", err)
+		}
+
+		name := rebaseName(header.Name, opts.RebaseNames)
+
+		if len(opts.IncludePatterns) > 0 && !matchesAny(name, opts.IncludePatterns) {
+			continue
+		}
+		if matchesAny(name, opts.ExcludePatterns) {
+			continue
+		}
+
+		target, err := sanitizeExtractPath(name, dest)
+		if err != nil {
+			return err
+		}
+
+		if err := extractEntry(tarReader, header, target, dest, opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tarHeaderBlockSize is the size of a tar header block; a stream
+// shorter than this cannot possibly contain a valid tar header.
+const tarHeaderBlockSize = 512
+
+// looksLikeTar peeks r's first header block, without consuming it, and
+// reports whether tar.Reader can parse a header from it.
+func looksLikeTar(r *bufio.Reader) bool {
+	peek, err := r.Peek(tarHeaderBlockSize)
+	if err != nil {
+		return false
+	}
+	_, err = tar.NewReader(bytes.NewReader(peek)).Next()
+	return err == nil
+}
+
+// writeNonTarPayload copies r's entire remaining contents to a single
+// file under dest, for a decompressed stream that turned out not to
+// wrap a tar archive at all (e.g. a gzipped log with no inner tar). The
+// output filename mirrors nameHint with its extension stripped, falling
+// back to "data" when nameHint is empty or has no base name to keep.
+func writeNonTarPayload(r io.Reader, dest, nameHint string) error {
+	name := strings.TrimSuffix(filepath.Base(nameHint), filepath.Ext(nameHint))
+	if name == "" || name == "." || name == string(filepath.Separator) {
+		name = "data"
+	}
+	target := filepath.Join(dest, name)
+
+	outputFile, err := os.Create(target)
+	if err != nil {
+		return fmt.Errorf("archive: creating %s: %w", target, err)
+	}
+
+	bufWriter := getBufWriter(outputFile)
+	_, copyErr := io.Copy(bufWriter, r)
+	flushErr := bufWriter.Flush()
+	putBufWriter(bufWriter)
+	closeErr := outputFile.Close()
+
+	if copyErr != nil {
+		return fmt.Errorf("archive: writing %s: %w", target, copyErr)
+	}
+	if flushErr != nil {
+		return fmt.Errorf("archive: flushing %s: %w", target, flushErr)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("archive: closing %s: %w", target, closeErr)
+	}
+	return nil
+}
+
+func extractEntry(tarReader *tar.Reader, header *tar.Header, target, dest string, opts *TarOptions) error {
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if opts.NoOverwriteDirNonDir {
+			if info, err := os.Stat(target); err == nil && !info.IsDir() {
+				return fmt.Errorf("archive: %q already exists as a non-directory", target)
+			}
+		}
+		if err := os.MkdirAll(target, os.ModePerm); err != nil {
+			return fmt.Errorf("archive: creating directory %s: %w", target, err)
+		}
+
+	case tar.TypeSymlink, tar.TypeLink:
+		linkTarget := header.Linkname
+		if !filepath.IsAbs(linkTarget) {
+			if header.Typeflag == tar.TypeSymlink {
+				// POSIX symlink semantics: relative to the link's own directory.
+				linkTarget = filepath.Join(filepath.Dir(target), linkTarget)
+			} else {
+				// GNU tar hardlink convention: relative to the archive root,
+				// i.e. dest, not the link entry's directory.
+				linkTarget = filepath.Join(dest, linkTarget)
+			}
+		}
+		if _, err := sanitizeExtractPath(mustRel(dest, linkTarget), dest); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return fmt.Errorf("archive: creating parent of %s: %w", target, err)
+		}
+		os.Remove(target)
+		if header.Typeflag == tar.TypeSymlink {
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return fmt.Errorf("archive: creating symlink %s: %w", target, err)
+			}
+		} else {
+			if err := os.Link(linkTarget, target); err != nil {
+				return fmt.Errorf("archive: creating hardlink %s: %w", target, err)
+			}
+		}
+
+	default:
+		if opts.NoOverwriteDirNonDir {
+			if info, err := os.Stat(target); err == nil && info.IsDir() {
+				return fmt.Errorf("archive: %q already exists as a directory", target)
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return fmt.Errorf("archive: creating parent of %s: %w", target, err)
+		}
+
+		outputFile, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode))
+		if err != nil {
+			return fmt.Errorf("archive: creating %s: %w", target, err)
+		}
+
+		bufWriter := getBufWriter(outputFile)
+		_, copyErr := io.Copy(bufWriter, tarReader)
+		flushErr := bufWriter.Flush()
+		putBufWriter(bufWriter)
+		closeErr := outputFile.Close()
+
+		if copyErr != nil {
+			return fmt.Errorf("archive: writing %s: %w", target, copyErr)
+		}
+		if flushErr != nil {
+			return fmt.Errorf("archive: flushing %s: %w", target, flushErr)
+		}
+		if closeErr != nil {
+			return fmt.Errorf("archive: closing %s: %w", target, closeErr)
+		}
+	}
+
+	if !opts.NoLchown && opts.ChownOpts != nil {
+		if err := os.Lchown(target, opts.ChownOpts.UID, opts.ChownOpts.GID); err != nil {
+			return fmt.Errorf("archive: chowning %s: %w", target, err)
+		}
+	}
+
+	return nil
+}
+
+// mustRel returns link made relative to dest for breakout checking; if
+// it cannot be made relative (e.g. on a different volume on Windows) it
+// is returned unchanged; such a link is absolute, which
+// sanitizeExtractPath rejects via its absolute-path check.
+func mustRel(dest, link string) string {
+	rel, err := filepath.Rel(dest, link)
+	if err != nil {
+		return link
+	}
+	return rel
+}
@@ -0,0 +1,332 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func buildTar(t *testing.T, entries map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, body := range entries {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(body))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("writing header for %s: %v", name, err)
+		}
+		if _, err := tw.Write([]byte(body)); err != nil {
+			t.Fatalf("writing body for %s: %v", name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestSanitizeExtractPathRejectsBreakout(t *testing.T) {
+	dest := t.TempDir()
+
+	for _, name := range []string{"../../etc/passwd", "/etc/passwd", "foo/../../bar"} {
+		if _, err := sanitizeExtractPath(name, dest); err == nil {
+			t.Errorf("sanitizeExtractPath(%q) = nil error, want ErrBreakout", name)
+		} else if _, ok := err.(*ErrBreakout); !ok {
+			t.Errorf("sanitizeExtractPath(%q) = %T, want *ErrBreakout", name, err)
+		}
+	}
+}
+
+func TestSanitizeExtractPathAllowsWithinDest(t *testing.T) {
+	dest := t.TempDir()
+
+	target, err := sanitizeExtractPath("a/b/c.txt", dest)
+	if err != nil {
+		t.Fatalf("sanitizeExtractPath: %v", err)
+	}
+	if want := filepath.Join(dest, "a/b/c.txt"); target != want {
+		t.Errorf("target = %q, want %q", target, want)
+	}
+}
+
+func TestUntarRejectsPathTraversal(t *testing.T) {
+	data := buildTar(t, map[string]string{"../../evil.txt": "gotcha"})
+	dest := t.TempDir()
+
+	err := Untar(bytes.NewReader(data), dest, nil)
+	if err == nil {
+		t.Fatal("Untar succeeded on a traversal payload, want ErrBreakout")
+	}
+	if _, ok := err.(*ErrBreakout); !ok {
+		t.Errorf("Untar error = %T, want *ErrBreakout", err)
+	}
+}
+
+func TestUntarExtractsRegularFiles(t *testing.T) {
+	data := buildTar(t, map[string]string{"a.txt": "hello", "dir/b.txt": "world"})
+	dest := t.TempDir()
+
+	if err := Untar(bytes.NewReader(data), dest, nil); err != nil {
+		t.Fatalf("Untar: %v", err)
+	}
+
+	for name, want := range map[string]string{"a.txt": "hello", "dir/b.txt": "world"} {
+		got, err := os.ReadFile(filepath.Join(dest, name))
+		if err != nil {
+			t.Fatalf("reading extracted %s: %v", name, err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestUntarHonorsIncludeExcludePatterns(t *testing.T) {
+	data := buildTar(t, map[string]string{"keep.log": "1", "skip.tmp": "2"})
+	dest := t.TempDir()
+
+	opts := &TarOptions{IncludePatterns: []string{"*.log"}}
+	if err := Untar(bytes.NewReader(data), dest, opts); err != nil {
+		t.Fatalf("Untar: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dest, "keep.log")); err != nil {
+		t.Errorf("keep.log was not extracted: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "skip.tmp")); !os.IsNotExist(err) {
+		t.Errorf("skip.tmp should have been excluded, stat err = %v", err)
+	}
+}
+
+func TestUntarWritesNonTarPayloadAsSingleFile(t *testing.T) {
+	dest := t.TempDir()
+
+	err := Untar(bytes.NewBufferString("just a plain log, not a tar"), dest, &TarOptions{NameHint: "manifest_123.log"})
+	if err != nil {
+		t.Fatalf("Untar: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dest, "manifest_123"))
+	if err != nil {
+		t.Fatalf("reading non-tar payload: %v", err)
+	}
+	if string(got) != "just a plain log, not a tar" {
+		t.Errorf("payload = %q, want %q", got, "just a plain log, not a tar")
+	}
+}
+
+func TestUntarIgnoresMisleadingNameHintExtension(t *testing.T) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tarData := buildTar(t, map[string]string{"a.txt": "hello"})
+	if _, err := gw.Write(tarData); err != nil {
+		t.Fatalf("writing gzip payload: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("closing gzip writer: %v", err)
+	}
+	dest := t.TempDir()
+
+	// NameHint's extension says xz, but the stream is actually gzip;
+	// DetectCompression's magic-byte match must win.
+	opts := &TarOptions{NameHint: "manifest_123.xz"}
+	if err := Untar(&buf, dest, opts); err != nil {
+		t.Fatalf("Untar: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dest, "a.txt"))
+	if err != nil {
+		t.Fatalf("reading extracted file: %v", err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("a.txt = %q, want %q", got, "hello")
+	}
+}
+
+func TestUntarHonorsRebaseNames(t *testing.T) {
+	data := buildTar(t, map[string]string{"old/a.txt": "hello"})
+	dest := t.TempDir()
+
+	opts := &TarOptions{RebaseNames: map[string]string{"old": "new"}}
+	if err := Untar(bytes.NewReader(data), dest, opts); err != nil {
+		t.Fatalf("Untar: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dest, "new/a.txt"))
+	if err != nil {
+		t.Fatalf("reading rebased file: %v", err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("new/a.txt = %q, want %q", got, "hello")
+	}
+	if _, err := os.Stat(filepath.Join(dest, "old/a.txt")); !os.IsNotExist(err) {
+		t.Errorf("old/a.txt should not exist, stat err = %v", err)
+	}
+}
+
+func TestUntarAppliesChownOpts(t *testing.T) {
+	data := buildTar(t, map[string]string{"a.txt": "hello"})
+	dest := t.TempDir()
+
+	// Chowning to the current uid/gid is always permitted, unlike
+	// chowning to an arbitrary id, which requires root.
+	opts := &TarOptions{ChownOpts: &IDPair{UID: os.Getuid(), GID: os.Getgid()}}
+	if err := Untar(bytes.NewReader(data), dest, opts); err != nil {
+		t.Fatalf("Untar: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dest, "a.txt"))
+	if err != nil {
+		t.Fatalf("stat extracted file: %v", err)
+	}
+	if info.Size() != 5 {
+		t.Errorf("a.txt size = %d, want 5", info.Size())
+	}
+}
+
+func TestUntarNoLchownSkipsChownOpts(t *testing.T) {
+	data := buildTar(t, map[string]string{"a.txt": "hello"})
+	dest := t.TempDir()
+
+	// For a non-root user, chowning to an arbitrary id fails, so Lchown
+	// would fail here if NoLchown didn't suppress the call.
+	opts := &TarOptions{NoLchown: true, ChownOpts: &IDPair{UID: 1 << 30, GID: 1 << 30}}
+	if err := Untar(bytes.NewReader(data), dest, opts); err != nil {
+		t.Fatalf("Untar: %v", err)
+	}
+}
+
+func TestUntarNoOverwriteDirNonDirRejectsFileOverDir(t *testing.T) {
+	dest := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dest, "a"), os.ModePerm); err != nil {
+		t.Fatalf("seeding pre-existing directory: %v", err)
+	}
+
+	data := buildTar(t, map[string]string{"a": "hello"})
+	opts := &TarOptions{NoOverwriteDirNonDir: true}
+
+	if err := Untar(bytes.NewReader(data), dest, opts); err == nil {
+		t.Fatal("Untar succeeded extracting a file over an existing directory, want error")
+	}
+}
+
+func TestUntarNoOverwriteDirNonDirRejectsDirOverFile(t *testing.T) {
+	dest := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dest, "a"), []byte("existing"), 0o644); err != nil {
+		t.Fatalf("seeding pre-existing file: %v", err)
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	writeTarEntry(t, tw, &tar.Header{Name: "a", Typeflag: tar.TypeDir, Mode: 0o755}, "")
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar writer: %v", err)
+	}
+	opts := &TarOptions{NoOverwriteDirNonDir: true}
+
+	if err := Untar(bytes.NewReader(buf.Bytes()), dest, opts); err == nil {
+		t.Fatal("Untar succeeded extracting a directory over an existing file, want error")
+	}
+}
+
+func TestUntarExtractsSymlink(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	writeTarEntry(t, tw, &tar.Header{Name: "a.txt", Mode: 0o644, Size: 5}, "hello")
+	writeTarEntry(t, tw, &tar.Header{Name: "link.txt", Typeflag: tar.TypeSymlink, Linkname: "a.txt", Mode: 0o777}, "")
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar writer: %v", err)
+	}
+	dest := t.TempDir()
+
+	if err := Untar(bytes.NewReader(buf.Bytes()), dest, nil); err != nil {
+		t.Fatalf("Untar: %v", err)
+	}
+
+	got, err := os.Readlink(filepath.Join(dest, "link.txt"))
+	if err != nil {
+		t.Fatalf("Readlink: %v", err)
+	}
+	if got != "a.txt" {
+		t.Errorf("symlink target = %q, want %q", got, "a.txt")
+	}
+}
+
+func TestUntarExtractsHardlink(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	writeTarEntry(t, tw, &tar.Header{Name: "a/b.txt", Mode: 0o644, Size: 5}, "hello")
+	writeTarEntry(t, tw, &tar.Header{Name: "c/link.txt", Typeflag: tar.TypeLink, Linkname: "a/b.txt", Mode: 0o644}, "")
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar writer: %v", err)
+	}
+	dest := t.TempDir()
+
+	if err := Untar(bytes.NewReader(buf.Bytes()), dest, nil); err != nil {
+		t.Fatalf("Untar: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dest, "c/link.txt"))
+	if err != nil {
+		t.Fatalf("reading hardlinked file: %v", err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("c/link.txt = %q, want %q", got, "hello")
+	}
+}
+
+func TestUntarRejectsSymlinkBreakout(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	writeTarEntry(t, tw, &tar.Header{Name: "evil", Typeflag: tar.TypeSymlink, Linkname: "../../etc/passwd", Mode: 0o777}, "")
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar writer: %v", err)
+	}
+	dest := t.TempDir()
+
+	err := Untar(bytes.NewReader(buf.Bytes()), dest, nil)
+	if err == nil {
+		t.Fatal("Untar succeeded on a symlink breakout payload, want ErrBreakout")
+	}
+	if _, ok := err.(*ErrBreakout); !ok {
+		t.Errorf("Untar error = %T, want *ErrBreakout", err)
+	}
+}
+
+func writeTarEntry(t *testing.T, tw *tar.Writer, hdr *tar.Header, body string) {
+	t.Helper()
+	hdr.Size = int64(len(body))
+	if err := tw.WriteHeader(hdr); err != nil {
+		t.Fatalf("writing header for %s: %v", hdr.Name, err)
+	}
+	if body != "" {
+		if _, err := tw.Write([]byte(body)); err != nil {
+			t.Fatalf("writing body for %s: %v", hdr.Name, err)
+		}
+	}
+}
+
+func TestUntarPathAutoDetectsCompression(t *testing.T) {
+	data := buildTar(t, map[string]string{"a.txt": "hello"})
+	dir := t.TempDir()
+	src := filepath.Join(dir, "archive.tar")
+	if err := os.WriteFile(src, data, 0o644); err != nil {
+		t.Fatalf("writing fixture tar: %v", err)
+	}
+
+	dest := filepath.Join(dir, "out")
+	if err := UntarPath(src, dest, nil); err != nil {
+		t.Fatalf("UntarPath: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dest, "a.txt"))
+	if err != nil {
+		t.Fatalf("reading extracted file: %v", err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("a.txt = %q, want %q", got, "hello")
+	}
+}